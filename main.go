@@ -1,6 +1,9 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -12,6 +15,9 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/adrg/xdg"
@@ -19,11 +25,23 @@ import (
 )
 
 const (
-	baseURL       = "https://my.glove80.com/api/layouts/v1/"
-	cacheFileName = "g80-layouts-cache.json"
-	cachePerms    = 0644
+	baseURL        = "https://my.glove80.com/api/layouts/v1/"
+	cacheFileName  = "g80-layouts-cache.json"
+	cachePerms     = 0644
+	maxRetries     = 3
+	initialBackoff = 500 * time.Millisecond
 )
 
+// ErrLayoutNotFound is returned when the meta endpoint reports 404 or
+// responds with a layout that has no UUID, e.g. a layout that's since been
+// deleted. Callers use errors.Is to distinguish this from a broken request.
+var ErrLayoutNotFound = errors.New("layout not found")
+
+// httpClient is shared by every request we make so the -timeout flag and
+// retry behavior apply consistently, whether we're fetching the UID search
+// or an individual layout's metadata.
+var httpClient = &http.Client{}
+
 type Layout struct {
 	Metadata struct {
 		UUID string `json:"uuid"`
@@ -55,23 +73,280 @@ func (l Layout) SemanticHash() string {
 	return fmt.Sprintf("%s-%s", l.Metadata.Title, l.Metadata.Creator)
 }
 
-func (l Layout) AsRow() []string {
-	date := l.Time().Format("1/2/06")
-	return []string{date, l.Metadata.Title, l.Metadata.Notes, l.Metadata.Creator}
+// defaultColumns matches what AsRow used to hard-code, so table output is
+// unchanged if -columns isn't passed.
+var defaultColumns = []string{"date", "title", "notes", "creator"}
+
+// columnExtractors is the single source of truth for which fields -columns
+// can select and how each renders as a string, shared by every tabular
+// Renderer (table, csv, markdown).
+var columnExtractors = map[string]struct {
+	header string
+	value  func(Layout) string
+}{
+	"date":        {"Date", func(l Layout) string { return l.Time().Format("1/2/06") }},
+	"title":       {"Title", func(l Layout) string { return l.Metadata.Title }},
+	"notes":       {"Notes", func(l Layout) string { return l.Metadata.Notes }},
+	"creator":     {"Author", func(l Layout) string { return l.Metadata.Creator }},
+	"uuid":        {"UUID", func(l Layout) string { return l.Metadata.UUID }},
+	"parent_uuid": {"Parent UUID", func(l Layout) string { return l.Metadata.ParentUUID }},
+	"tags":        {"Tags", func(l Layout) string { return strings.Join(l.Metadata.Tags, ",") }},
+}
+
+// columnHeaders and columnRow let every tabular Renderer share the same
+// -columns handling instead of each reimplementing the lookup.
+func columnHeaders(columns []string) []string {
+	headers := make([]string, len(columns))
+	for i, col := range columns {
+		headers[i] = columnExtractors[col].header
+	}
+	return headers
+}
+
+func columnRow(l Layout, columns []string) []string {
+	row := make([]string, len(columns))
+	for i, col := range columns {
+		row[i] = columnExtractors[col].value(l)
+	}
+	return row
+}
+
+// parseColumns validates a comma-separated -columns value against
+// columnExtractors.
+func parseColumns(raw string) ([]string, error) {
+	columns := strings.Split(raw, ",")
+	for _, col := range columns {
+		if _, ok := columnExtractors[col]; !ok {
+			return nil, fmt.Errorf("unknown column %q", col)
+		}
+	}
+	return columns, nil
+}
+
+// Renderer writes a set of layouts to w in a particular output format.
+// SemanticHash dedup happens before Render is called, regardless of format.
+type Renderer interface {
+	Render(w io.Writer, layouts []Layout) error
+}
+
+// tableRenderer is the original tablewriter-based terminal output.
+type tableRenderer struct {
+	columns []string
+}
+
+func (r tableRenderer) Render(w io.Writer, layouts []Layout) error {
+	table := tablewriter.NewWriter(w)
+	table.SetHeader(columnHeaders(r.columns))
+	for _, layout := range layouts {
+		table.Append(columnRow(layout, r.columns))
+	}
+	table.Render()
+	return nil
+}
+
+// jsonRenderer writes the full Layout objects, not just the selected
+// columns, since JSON consumers like jq can already pick out whatever
+// fields they want.
+type jsonRenderer struct{}
+
+func (r jsonRenderer) Render(w io.Writer, layouts []Layout) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(layouts)
+}
+
+// csvRenderer writes a header row mirroring the selected columns, the way
+// AsRow used to define the table's fixed four.
+type csvRenderer struct {
+	columns []string
+}
+
+func (r csvRenderer) Render(w io.Writer, layouts []Layout) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(columnHeaders(r.columns)); err != nil {
+		return err
+	}
+	for _, layout := range layouts {
+		if err := cw.Write(columnRow(layout, r.columns)); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// markdownRenderer writes a GitHub-flavored markdown table, suitable for
+// pasting into a PR description or a GitHub Actions step summary.
+type markdownRenderer struct {
+	columns []string
+}
+
+// escapeMarkdownCell makes a cell value safe to place inside a GFM table
+// row: "|" would otherwise be read as a column separator, and a raw newline
+// would split the row across lines, both corrupting the table.
+func escapeMarkdownCell(cell string) string {
+	cell = strings.ReplaceAll(cell, "|", "\\|")
+	cell = strings.ReplaceAll(cell, "\r\n", " ")
+	cell = strings.ReplaceAll(cell, "\n", " ")
+	return cell
+}
+
+func (r markdownRenderer) Render(w io.Writer, layouts []Layout) error {
+	headers := columnHeaders(r.columns)
+	if _, err := fmt.Fprintf(w, "| %s |\n", strings.Join(headers, " | ")); err != nil {
+		return err
+	}
+	separators := make([]string, len(headers))
+	for i := range separators {
+		separators[i] = "---"
+	}
+	if _, err := fmt.Fprintf(w, "| %s |\n", strings.Join(separators, " | ")); err != nil {
+		return err
+	}
+	for _, layout := range layouts {
+		row := columnRow(layout, r.columns)
+		for i, cell := range row {
+			row[i] = escapeMarkdownCell(cell)
+		}
+		if _, err := fmt.Fprintf(w, "| %s |\n", strings.Join(row, " | ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LayoutComparator orders two layouts three-way, like strings.Compare:
+// negative if a sorts before b, positive if after, zero if equal on this
+// field.
+type LayoutComparator func(a, b Layout) int
+
+// Reverse flips a comparator, turning an ascending field into a descending
+// one.
+func Reverse(cmp LayoutComparator) LayoutComparator {
+	return func(a, b Layout) int { return -cmp(a, b) }
+}
+
+// Chain tries each comparator in order, falling through to the next one
+// whenever the current field compares equal, so e.g. "creator,-date" breaks
+// ties on date once creator matches.
+func Chain(cmps ...LayoutComparator) LayoutComparator {
+	return func(a, b Layout) int {
+		for _, cmp := range cmps {
+			if c := cmp(a, b); c != 0 {
+				return c
+			}
+		}
+		return 0
+	}
+}
+
+// sortComparators is the single source of truth for which fields -sort can
+// select, one comparator per sortable field.
+var sortComparators = map[string]LayoutComparator{
+	"date": func(a, b Layout) int {
+		switch {
+		case a.Metadata.Date < b.Metadata.Date:
+			return -1
+		case a.Metadata.Date > b.Metadata.Date:
+			return 1
+		default:
+			return 0
+		}
+	},
+	"title":   func(a, b Layout) int { return strings.Compare(a.Metadata.Title, b.Metadata.Title) },
+	"creator": func(a, b Layout) int { return strings.Compare(a.Metadata.Creator, b.Metadata.Creator) },
+}
+
+// parseSort turns a -sort value like "creator,-date" into a single
+// comparator: a leading "-" on a key means descending, and keys are
+// combined with Chain in the order given.
+func parseSort(raw string) (LayoutComparator, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	keys := strings.Split(raw, ",")
+	cmps := make([]LayoutComparator, 0, len(keys))
+	for _, key := range keys {
+		descending := strings.HasPrefix(key, "-")
+		key = strings.TrimPrefix(key, "-")
+		cmp, ok := sortComparators[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown sort key %q", key)
+		}
+		if descending {
+			cmp = Reverse(cmp)
+		}
+		cmps = append(cmps, cmp)
+	}
+	return Chain(cmps...), nil
+}
+
+// newRenderer selects a Renderer for the given -format value.
+func newRenderer(format string, columns []string) (Renderer, error) {
+	switch format {
+	case "table":
+		return tableRenderer{columns: columns}, nil
+	case "json":
+		return jsonRenderer{}, nil
+	case "csv":
+		return csvRenderer{columns: columns}, nil
+	case "markdown":
+		return markdownRenderer{columns: columns}, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// CacheRecord wraps a Layout with the time it was fetched so getLayout can
+// decide whether the entry is stale.
+type CacheRecord struct {
+	Layout    Layout
+	FetchedAt time.Time
 }
 
 var (
-	cache     = make(map[string]Layout)
+	cache     = make(map[string]CacheRecord)
+	cacheMu   sync.Mutex
 	cachePath = ""
+	cacheTTL  time.Duration
 )
 
+// readCache loads the on-disk cache. Older cache files hold a bare
+// map[string]Layout; its keys (layout_meta, config, ...) don't match
+// CacheRecord's own field names, so a lenient decode would silently
+// succeed with every entry zeroed out instead of erroring. We decode with
+// DisallowUnknownFields so that legacy shape is rejected here and falls
+// through to the legacy path below, which treats every entry as "fetched
+// long ago" so a stale TTL check refetches them instead of trusting them
+// forever.
 func readCache() {
 	cacheBytes, err := ioutil.ReadFile(cachePath)
 	if err != nil && !errors.Is(err, fs.ErrNotExist) {
 		log.Print("Could not read layout cache.")
 		panic(err)
 	}
-	json.Unmarshal(cacheBytes, &cache)
+	if len(cacheBytes) == 0 {
+		return
+	}
+
+	var current map[string]CacheRecord
+	dec := json.NewDecoder(bytes.NewReader(cacheBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&current); err == nil {
+		cache = current
+		return
+	}
+
+	var legacy map[string]Layout
+	if err := json.Unmarshal(cacheBytes, &legacy); err != nil {
+		log.Print("Could not parse layout cache, starting fresh.")
+		return
+	}
+	log.Print("Migrating legacy cache file to the CacheRecord schema.")
+	longAgo := time.Unix(0, 0)
+	for uid, layout := range legacy {
+		cache[uid] = CacheRecord{Layout: layout, FetchedAt: longAgo}
+	}
 }
 
 func writeCache() {
@@ -88,9 +363,55 @@ func writeCache() {
 	log.Print("Successfully wrote cache to disk.")
 }
 
-func getLayout(uid string) Layout {
-	if _, ok := cache[uid]; ok {
-		return cache[uid]
+// cacheFresh reports whether a record fetched at fetchedAt is still good to
+// use under the configured -cache-ttl. ttl == 0 means entries never expire;
+// ttl < 0 means always refetch.
+func cacheFresh(fetchedAt time.Time) bool {
+	if cacheTTL == 0 {
+		return true
+	}
+	if cacheTTL < 0 {
+		return false
+	}
+	return time.Since(fetchedAt) <= cacheTTL
+}
+
+// doRequest runs req with a small retry loop: 5xx responses and network
+// errors are retried with exponential backoff, bounded by ctx, before giving
+// up and returning the last error seen.
+func doRequest(ctx context.Context, req *http.Request) (*http.Response, error) {
+	var lastErr error
+	backoff := initialBackoff
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		resp, err := httpClient.Do(req.Clone(ctx))
+		if err == nil && resp.StatusCode < 500 {
+			return resp, nil
+		}
+		if err != nil {
+			lastErr = err
+		} else {
+			resp.Body.Close()
+			lastErr = fmt.Errorf("server error: %s", resp.Status)
+		}
+		if attempt == maxRetries {
+			break
+		}
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return nil, lastErr
+}
+
+func getLayout(ctx context.Context, uid string) (Layout, error) {
+	cacheMu.Lock()
+	record, ok := cache[uid]
+	cacheMu.Unlock()
+	if ok && cacheFresh(record.FetchedAt) {
+		return record.Layout, nil
 	}
 
 	layoutURL, err := url.Parse(baseURL)
@@ -102,18 +423,99 @@ func getLayout(uid string) Layout {
 		panic(err)
 	}
 	log.Printf("Requesting layout: %s", layoutURL.String())
-	resp, err := http.Get(layoutURL.String())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, layoutURL.String(), nil)
 	if err != nil {
 		panic(err)
 	}
+	resp, err := doRequest(ctx, req)
+	if err != nil {
+		return Layout{}, fmt.Errorf("fetching layout %s: %w", uid, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return Layout{}, ErrLayoutNotFound
+	}
 	layoutBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		panic(err)
+		return Layout{}, fmt.Errorf("reading layout %s: %w", uid, err)
 	}
 	result := Layout{}
 	json.Unmarshal(layoutBytes, &result)
-	cache[uid] = result
-	return result
+	if result.Metadata.UUID == "" {
+		return Layout{}, ErrLayoutNotFound
+	}
+	cacheMu.Lock()
+	cache[uid] = CacheRecord{Layout: result, FetchedAt: time.Now()}
+	cacheMu.Unlock()
+	return result, nil
+}
+
+// layoutResult carries one worker's outcome back to the collector, tagged
+// with its original index so results can be reassembled in request order.
+type layoutResult struct {
+	index  int
+	layout Layout
+	err    error
+}
+
+// fetchLayouts fetches each uid's metadata using a bounded pool of
+// concurrency workers, all sharing ctx so -timeout bounds the whole batch.
+// A layout that comes back ErrLayoutNotFound, or that -timeout/cancellation
+// cut off before it could finish (or even start), is logged and skipped
+// rather than aborting the run; any other error aborts it.
+func fetchLayouts(ctx context.Context, uids []string, concurrency int) []Layout {
+	jobs := make(chan int)
+	results := make(chan layoutResult, len(uids))
+
+	var workers sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for i := range jobs {
+				layout, err := getLayout(ctx, uids[i])
+				results <- layoutResult{index: i, layout: layout, err: err}
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for i := range uids {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+
+	ordered := make([]layoutResult, len(uids))
+	dispatched := make([]bool, len(uids))
+	for res := range results {
+		ordered[res.index] = res
+		dispatched[res.index] = true
+	}
+
+	layouts := make([]Layout, 0, len(uids))
+	for i, res := range ordered {
+		if !dispatched[i] {
+			log.Printf("Skipping %s: never dispatched, %s", uids[i], ctx.Err())
+			continue
+		}
+		if res.err != nil {
+			if errors.Is(res.err, ErrLayoutNotFound) || errors.Is(res.err, context.DeadlineExceeded) || errors.Is(res.err, context.Canceled) {
+				log.Printf("Skipping %s: %s", uids[i], res.err)
+				continue
+			}
+			panic(res.err)
+		}
+		layouts = append(layouts, res.layout)
+	}
+	return layouts
 }
 
 func main() {
@@ -126,26 +528,56 @@ func main() {
 	}
 
 	var (
-		debug  bool
-		limit  int
-		offset int
-		redupe bool
+		debug       bool
+		limit       int
+		offset      int
+		redupe      bool
+		timeout     time.Duration
+		concurrency int
+		format      string
+		columnsFlag string
+		sortFlag    string
 	)
 	flag.BoolVar(&debug, "debug", false, "Whether to print debug statements")
 	flag.BoolVar(&redupe, "redupe", false, "Whether to show layouts with the same title by the same creator")
 	flag.IntVar(&limit, "limit", 10, "How many layouts to show")
 	flag.IntVar(&offset, "offset", 0, "How many layouts to skip")
+	flag.DurationVar(&cacheTTL, "cache-ttl", 24*time.Hour, "How long a cached layout is trusted before refetching; 0 = never expire, negative = always refetch")
+	flag.DurationVar(&timeout, "timeout", 10*time.Second, "How long the whole batch of requests is allowed to take")
+	flag.IntVar(&concurrency, "concurrency", 4, "How many layout metadata requests to run at once")
+	flag.StringVar(&format, "format", "table", "Output format: table, json, csv, or markdown")
+	flag.StringVar(&columnsFlag, "columns", strings.Join(defaultColumns, ","), "Comma separated columns to show: date, title, notes, creator, uuid, parent_uuid, tags")
+	flag.StringVar(&sortFlag, "sort", "", "Comma separated sort keys, e.g. creator,-date (leading - means descending); unset leaves API order")
 	flag.Parse()
 	args := flag.Args()
 	if len(args) > 1 {
 		log.Fatalf("%s only takes 1 argument at most (a comma separated list of tags to search for)", os.Args[0])
 	}
+	columns, err := parseColumns(columnsFlag)
+	if err != nil {
+		log.Fatalf("Invalid -columns: %s", err)
+	}
+	comparator, err := parseSort(sortFlag)
+	if err != nil {
+		log.Fatalf("Invalid -sort: %s", err)
+	}
+	if concurrency < 1 {
+		log.Fatalf("Invalid -concurrency: %d, must be at least 1", concurrency)
+	}
+	renderer, err := newRenderer(format, columns)
+	if err != nil {
+		log.Fatalf("Invalid -format: %s", err)
+	}
 	if !debug {
 		// This is a script, so we're just going to panic if anything goes
 		// wrong. I.e. all logs are for debugging.
 		log.Default().SetOutput(io.Discard)
 	}
 
+	httpClient.Timeout = timeout
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
 	readCache()
 	defer writeCache()
 
@@ -160,7 +592,15 @@ func main() {
 		searchURL.RawQuery = query.Encode()
 	}
 	log.Printf("Requesting layout unique IDs: %s", searchURL.String())
-	resp, err := http.Get(searchURL.String())
+	searchReq, err := http.NewRequestWithContext(ctx, http.MethodGet, searchURL.String(), nil)
+	if err != nil {
+		panic(err)
+	}
+	resp, err := doRequest(ctx, searchReq)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
 	var uids []string
 	uidBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -171,24 +611,29 @@ func main() {
 		panic(err)
 	}
 
+	layouts := fetchLayouts(ctx, uids[offset:offset+limit], concurrency)
+
+	if comparator != nil {
+		sort.Slice(layouts, func(i, j int) bool { return comparator(layouts[i], layouts[j]) < 0 })
+	}
+
 	seenLayouts := make(map[string]struct{})
-	var rows [][]string
-	for _, uid := range uids[offset : offset+limit] {
-		layout := getLayout(uid)
+	var deduped []Layout
+	for _, layout := range layouts {
 		if redupe {
-			rows = append(rows, layout.AsRow())
+			deduped = append(deduped, layout)
 			continue
 		}
 
 		hash := layout.SemanticHash()
 		_, exists := seenLayouts[hash]
 		if !exists {
-			rows = append(rows, layout.AsRow())
+			deduped = append(deduped, layout)
 			seenLayouts[hash] = struct{}{}
 		}
 	}
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Date", "Title", "Notes", "Author"})
-	table.AppendBulk(rows)
-	table.Render()
+
+	if err := renderer.Render(os.Stdout, deduped); err != nil {
+		panic(err)
+	}
 }